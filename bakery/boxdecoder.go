@@ -0,0 +1,88 @@
+package bakery
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"golang.org/x/crypto/nacl/box"
+
+	"gopkg.in/errgo.v1"
+)
+
+const (
+	boxNonceLen = 24
+	boxKeyLen   = 32
+)
+
+// boxDecoder opens third-party caveat ids that were sealed to key's
+// public key by a boxEncoder (see encodeCaveatId), recognising both the
+// Version1 base64+JSON framing and the Version2 binary framing.
+type boxDecoder struct {
+	key *KeyPair
+}
+
+func newBoxDecoder(key *KeyPair) *boxDecoder {
+	return &boxDecoder{key: key}
+}
+
+// decodeCaveatId recovers the root key and condition sealed inside id.
+// It detects which of encodeCaveatId's wire formats id was produced
+// with the same way caveatIdVersion does, so callers that only have the
+// id (such as Discharge) don't need to know the version up front.
+func (d *boxDecoder) decodeCaveatId(id string) ([]byte, string, error) {
+	switch caveatIdVersion(id) {
+	case Version2:
+		return d.decodeV2(id)
+	default:
+		return d.decodeV1(id)
+	}
+}
+
+// decodeV1 parses the base64-encoded JSON caveatIdRecord produced by
+// encodeCaveatId's Version1 case.
+func (d *boxDecoder) decodeV1(id string) ([]byte, string, error) {
+	data, err := base64.StdEncoding.DecodeString(id)
+	if err != nil {
+		return nil, "", errgo.Notef(err, "cannot base64-decode caveat id")
+	}
+	var rec caveatIdRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, "", errgo.Notef(err, "cannot unmarshal caveat id")
+	}
+	if len(rec.Nonce) != boxNonceLen {
+		return nil, "", errgo.Newf("caveat id nonce has unexpected length %d", len(rec.Nonce))
+	}
+	var nonce [boxNonceLen]byte
+	copy(nonce[:], rec.Nonce)
+	return d.open(rec.Id, &nonce, &rec.FirstPartyPublicKey)
+}
+
+// decodeV2 parses the binary framing produced by encodeCaveatId's
+// Version2 case: firstPartyPublicKey(32) || nonce(24) || ciphertext.
+func (d *boxDecoder) decodeV2(id string) ([]byte, string, error) {
+	data := []byte(id)
+	if len(data) < boxKeyLen+boxNonceLen {
+		return nil, "", errgo.Newf("version 2 caveat id is too short")
+	}
+	var firstPartyPub PublicKey
+	copy(firstPartyPub.Key[:], data[:boxKeyLen])
+	sealed := data[boxKeyLen:]
+	var nonce [boxNonceLen]byte
+	copy(nonce[:], sealed[:boxNonceLen])
+	return d.open(sealed, &nonce, &firstPartyPub)
+}
+
+// open unboxes sealed (nonce(24)||ciphertext, as produced by box.Seal)
+// using d's private key and firstPartyPub, and unmarshals the result
+// into the RootKey and Condition it was created from.
+func (d *boxDecoder) open(sealed []byte, nonce *[boxNonceLen]byte, firstPartyPub *PublicKey) ([]byte, string, error) {
+	plain, ok := box.Open(nil, sealed[boxNonceLen:], nonce, firstPartyPub.boxKey(), d.key.Private.boxKey())
+	if !ok {
+		return nil, "", errgo.Newf("cannot decrypt caveat id")
+	}
+	var cid caveatId
+	if err := json.Unmarshal(plain, &cid); err != nil {
+		return nil, "", errgo.Notef(err, "cannot unmarshal decrypted caveat id")
+	}
+	return cid.RootKey, cid.Condition, nil
+}
@@ -0,0 +1,85 @@
+package bakery
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+
+	"golang.org/x/crypto/nacl/box"
+
+	"gopkg.in/errgo.v1"
+)
+
+// caveatId is the plaintext sealed inside a third-party caveat id: the
+// root key the discharge macaroon must be created with, and the
+// caveat's condition, to be checked by the third party.
+type caveatId struct {
+	RootKey   []byte
+	Condition string
+}
+
+// caveatIdRecord is the Version1 wire representation of a third-party
+// caveat id: a NaCl box-sealed caveatId, together with the information
+// the third party needs to open it.
+type caveatIdRecord struct {
+	ThirdPartyPublicKey PublicKey
+	FirstPartyPublicKey PublicKey
+	Nonce               []byte
+	Id                  []byte
+}
+
+// boxEncoder creates third-party caveat ids sealed, with NaCl box, to
+// the third party's public key.
+type boxEncoder struct {
+	key *KeyPair
+}
+
+func newBoxEncoder(key *KeyPair) *boxEncoder {
+	return &boxEncoder{key: key}
+}
+
+// encodeCaveatId returns a caveat id that seals rootKey and condition to
+// thirdPartyPub, in the wire format selected by v. Version1 produces a
+// base64-encoded JSON caveatIdRecord, matching earlier releases.
+// Version2 produces raw binary bytes, firstPartyPublicKey(32) ||
+// nonce(24) || ciphertext, with no base64 or JSON framing, for interop
+// with peers that expect binary caveat ids (see Version).
+func (e *boxEncoder) encodeCaveatId(condition string, rootKey []byte, thirdPartyPub *PublicKey, v Version) (string, error) {
+	plain, err := json.Marshal(caveatId{
+		RootKey:   rootKey,
+		Condition: condition,
+	})
+	if err != nil {
+		return "", errgo.Notef(err, "cannot marshal caveat id")
+	}
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return "", errgo.Notef(err, "cannot generate nonce")
+	}
+	sealed := box.Seal(nonce[:], plain, &nonce, thirdPartyPub.boxKey(), e.key.Private.boxKey())
+
+	switch v {
+	case Version2:
+		// Binary framing: firstPartyPublicKey(32) || sealed, where
+		// sealed is nonce(24)||ciphertext, as box.Seal produced it
+		// above. The third party needs the first-party's public key
+		// to open the box, so it travels with the id rather than
+		// being looked up separately; everything after it is opaque
+		// to anything but NaCl box.
+		id := make([]byte, 0, len(e.key.Public.Key)+len(sealed))
+		id = append(id, e.key.Public.Key[:]...)
+		id = append(id, sealed...)
+		return string(id), nil
+	default:
+		data, err := json.Marshal(caveatIdRecord{
+			ThirdPartyPublicKey: *thirdPartyPub,
+			FirstPartyPublicKey: e.key.Public,
+			Nonce:               nonce[:],
+			Id:                  sealed,
+		})
+		if err != nil {
+			return "", errgo.Notef(err, "cannot marshal caveat id record")
+		}
+		return base64.StdEncoding.EncodeToString(data), nil
+	}
+}
@@ -0,0 +1,16 @@
+package bakery
+
+// boxKey adapts the bakery's own PublicKey/PrivateKey representation
+// (each a 32-byte curve25519 key, as used throughout this package - see
+// KeyPair) to the *[32]byte golang.org/x/crypto/nacl/box expects.
+func (k *PublicKey) boxKey() *[32]byte {
+	var out [32]byte
+	copy(out[:], k.Key[:])
+	return &out
+}
+
+func (k *PrivateKey) boxKey() *[32]byte {
+	var out [32]byte
+	copy(out[:], k.Key[:])
+	return &out
+}
@@ -0,0 +1,88 @@
+package bakery
+
+import (
+	"sync"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon.v1"
+
+	"github.com/flynn/macaroon-bakery/bakery/checkers"
+)
+
+// checkerRegistry holds a Service's registered first-party caveat
+// checker functions, keyed by caveat condition, falling back to the
+// standard checkers (time-before, allow, deny, error and so on) for any
+// condition that has not been explicitly registered.
+type checkerRegistry struct {
+	mu       sync.Mutex
+	checkers map[string]func(cond, arg string) error
+}
+
+func newCheckerRegistry() *checkerRegistry {
+	r := &checkerRegistry{
+		checkers: make(map[string]func(cond, arg string) error),
+	}
+	return r
+}
+
+// register adds fn under name, unless a checker is already registered
+// under that name, in which case it is silently ignored: the first
+// registration wins, so that a package's init-time registration cannot
+// be clobbered by another package later in the import graph.
+func (r *checkerRegistry) register(name string, fn func(cond, arg string) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.checkers[name]; ok {
+		return
+	}
+	r.checkers[name] = fn
+}
+
+// CheckFirstPartyCaveat implements checkers.Checker by dispatching to
+// whichever checker is registered for the caveat's condition, falling
+// back to the standard checkers for anything that was not explicitly
+// registered with RegisterChecker.
+func (r *checkerRegistry) CheckFirstPartyCaveat(caveat string) error {
+	cond, arg, err := checkers.ParseCaveat(caveat)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	r.mu.Lock()
+	fn := r.checkers[cond]
+	r.mu.Unlock()
+	if fn == nil {
+		return checkers.Std.CheckFirstPartyCaveat(caveat)
+	}
+	return fn(cond, arg)
+}
+
+// RegisterChecker registers fn as the checker for first-party caveats
+// with the given condition, overriding the standard checker (if any)
+// for that condition. If a checker is already registered under name,
+// RegisterChecker does nothing, matching the "first registration wins"
+// behaviour of other LND-derived services.
+func (svc *Service) RegisterChecker(name string, fn func(cond, arg string) error) {
+	svc.checkers.register(name, fn)
+}
+
+// Checkers returns the checkers.Checker formed by merging every checker
+// registered on svc with the standard checkers, for callers that want
+// to extend it further (for example by composing it with checkers.New
+// themselves).
+func (svc *Service) Checkers() checkers.Checker {
+	return svc.checkers
+}
+
+// Verify checks that ms verifies correctly against svc, using the
+// checkers registered on svc (see RegisterChecker) plus the "declared"
+// attributes inferred from ms itself. It returns the declared
+// attributes of the successfully verified macaroon. Callers that need a
+// custom FirstPartyChecker should use Check or CheckWithKey instead.
+func (svc *Service) Verify(ms macaroon.Slice) (map[string]string, error) {
+	declared := checkers.InferDeclared(ms)
+	checker := checkers.New(declared, svc.Checkers())
+	if err := svc.Check(ms, checker); err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	return declared, nil
+}
@@ -0,0 +1,104 @@
+package bakery
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon.v1"
+
+	"github.com/flynn/macaroon-bakery/bakery/checkers"
+)
+
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	svc, err := NewService(NewServiceParams{
+		Location: "test",
+		Store:    NewMemExpiringStorage(),
+	})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	return svc
+}
+
+// TestVerifyUsesStandardCheckers checks that Verify succeeds on a
+// macaroon carrying nothing but a standard caveat (time-before) without
+// the caller ever touching checkers.New itself.
+func TestVerifyUsesStandardCheckers(t *testing.T) {
+	svc := newTestService(t)
+	m, err := svc.NewMacaroon("", nil, []checkers.Caveat{
+		checkers.TimeBeforeCaveat(time.Now().Add(time.Hour)),
+	})
+	if err != nil {
+		t.Fatalf("NewMacaroon: %v", err)
+	}
+	if _, err := svc.Verify(macaroon.Slice{m}); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	expired, err := svc.NewMacaroon("", nil, []checkers.Caveat{
+		checkers.TimeBeforeCaveat(time.Now().Add(-time.Hour)),
+	})
+	if err != nil {
+		t.Fatalf("NewMacaroon: %v", err)
+	}
+	if _, err := svc.Verify(macaroon.Slice{expired}); err == nil {
+		t.Fatalf("Verify unexpectedly succeeded on an expired macaroon")
+	}
+}
+
+// TestRegisterCheckerFirstWins is the regression test for
+// RegisterChecker's documented "first registration wins" behaviour: a
+// second registration under the same name must be silently ignored.
+func TestRegisterCheckerFirstWins(t *testing.T) {
+	svc := newTestService(t)
+	svc.RegisterChecker("custom", func(cond, arg string) error {
+		return nil
+	})
+	svc.RegisterChecker("custom", func(cond, arg string) error {
+		return errgo.New("second registration should never run")
+	})
+	m, err := svc.NewMacaroon("", nil, []checkers.Caveat{
+		{Condition: "custom something"},
+	})
+	if err != nil {
+		t.Fatalf("NewMacaroon: %v", err)
+	}
+	if _, err := svc.Verify(macaroon.Slice{m}); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+}
+
+// TestVerifyConsultsRegisteredChecker checks that a custom checker
+// registered with RegisterChecker is actually used by Verify, both to
+// allow and to deny.
+func TestVerifyConsultsRegisteredChecker(t *testing.T) {
+	svc := newTestService(t)
+	svc.RegisterChecker("custom", func(cond, arg string) error {
+		if arg != "allowed" {
+			return errgo.Newf("custom condition not satisfied: %q", arg)
+		}
+		return nil
+	})
+
+	m, err := svc.NewMacaroon("", nil, []checkers.Caveat{
+		{Condition: "custom allowed"},
+	})
+	if err != nil {
+		t.Fatalf("NewMacaroon: %v", err)
+	}
+	if _, err := svc.Verify(macaroon.Slice{m}); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	denied, err := svc.NewMacaroon("", nil, []checkers.Caveat{
+		{Condition: "custom denied"},
+	})
+	if err != nil {
+		t.Fatalf("NewMacaroon: %v", err)
+	}
+	if _, err := svc.Verify(macaroon.Slice{denied}); err == nil {
+		t.Fatalf("Verify unexpectedly succeeded against the registered checker")
+	}
+}
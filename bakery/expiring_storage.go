@@ -0,0 +1,226 @@
+package bakery
+
+import (
+	"container/heap"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/flynn/macaroon-bakery/bakery/checkers"
+)
+
+// earliestExpiry returns the earliest time-before caveat found in
+// caveats, or the zero time if there is none. It is used by NewMacaroon
+// to tell an ExpiringStorage when a minted macaroon's root key may be
+// garbage collected.
+func earliestExpiry(caveats []checkers.Caveat) time.Time {
+	var earliest time.Time
+	for _, cav := range caveats {
+		if cav.Location != "" {
+			continue
+		}
+		cond, arg, err := checkers.ParseCaveat(cav.Condition)
+		if err != nil || cond != checkers.CondTimeBefore {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, arg)
+		if err != nil {
+			continue
+		}
+		if earliest.IsZero() || t.Before(earliest) {
+			earliest = t
+		}
+	}
+	return earliest
+}
+
+// putWithExpiry is like storage.Put but, if the underlying Storage
+// implements ExpiringStorage and expiry is non-zero, asks it to remove
+// the item once expiry has passed.
+func (s *storage) putWithExpiry(location string, item *storageItem, expiry time.Time) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return errgo.Notef(err, "cannot marshal storage item")
+	}
+	es, ok := s.store.(ExpiringStorage)
+	if !ok || expiry.IsZero() {
+		return s.store.Put(location, string(data))
+	}
+	return es.PutWithExpiry(location, string(data), expiry)
+}
+
+// ExpiringStorage is implemented by a Storage that can associate an
+// expiry time with a stored item, so that it can be removed once it is
+// no longer needed instead of accumulating forever. Service.NewMacaroon
+// uses it automatically, when the store implements it, for any macaroon
+// whose caveats include a checkers.TimeBeforeCaveat.
+type ExpiringStorage interface {
+	Storage
+
+	// PutWithExpiry stores the item at the given location, overwriting
+	// any existing value, and arranges for it to be removed at or
+	// after expiry.
+	PutWithExpiry(location, item string, expiry time.Time) error
+}
+
+// gcStorage is implemented by an ExpiringStorage that runs its own
+// background eviction goroutine, started and stopped via
+// Service.StartGC and Service.StopGC.
+type gcStorage interface {
+	StartGC(interval time.Duration)
+	StopGC()
+}
+
+// StartGC starts a goroutine that periodically evicts expired items
+// from svc's store, if the store supports it (see NewMemExpiringStorage).
+// It is a no-op if the store does not implement background GC.
+func (svc *Service) StartGC(interval time.Duration) {
+	if gc, ok := svc.Store().(gcStorage); ok {
+		gc.StartGC(interval)
+	}
+}
+
+// StopGC stops the goroutine started by StartGC. It is a no-op if the
+// store does not implement background GC, or if StartGC was never
+// called.
+func (svc *Service) StopGC() {
+	if gc, ok := svc.Store().(gcStorage); ok {
+		gc.StopGC()
+	}
+}
+
+// memExpiringStorage is an in-memory ExpiringStorage that evicts items
+// once they pass their expiry time, using a min-heap keyed by expiry so
+// that eviction never has to scan the whole store.
+type memExpiringStorage struct {
+	mu    sync.Mutex
+	items map[string]string
+	// expiryAt holds the expiry time of every item put with a
+	// non-zero expiry, so that Get can reject an expired item in
+	// O(1) without waiting for the background GC goroutine.
+	expiryAt map[string]time.Time
+	expiry   expiryHeap
+
+	stop chan struct{}
+}
+
+// NewMemExpiringStorage returns a new in-memory ExpiringStorage. Unlike
+// NewMemStorage, items stored via PutWithExpiry are automatically
+// removed once their expiry has passed, either lazily on Get or, once
+// StartGC has been called, by a background goroutine.
+func NewMemExpiringStorage() ExpiringStorage {
+	return &memExpiringStorage{
+		items:    make(map[string]string),
+		expiryAt: make(map[string]time.Time),
+	}
+}
+
+func (s *memExpiringStorage) Put(location, item string) error {
+	return s.PutWithExpiry(location, item, time.Time{})
+}
+
+func (s *memExpiringStorage) PutWithExpiry(location, item string, expiry time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[location] = item
+	if expiry.IsZero() {
+		delete(s.expiryAt, location)
+		return nil
+	}
+	s.expiryAt[location] = expiry
+	heap.Push(&s.expiry, expiryItem{location: location, expiry: expiry})
+	return nil
+}
+
+func (s *memExpiringStorage) Get(location string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if expiry, ok := s.expiryAt[location]; ok && !expiry.After(time.Now()) {
+		delete(s.items, location)
+		delete(s.expiryAt, location)
+		return "", ErrNotFound
+	}
+	item, ok := s.items[location]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return item, nil
+}
+
+// evictExpired removes every item whose expiry is at or before now. A
+// heap entry whose expiry no longer matches expiryAt is stale (the
+// item was re-Put with a later expiry, or already evicted by Get) and
+// is discarded without touching items.
+func (s *memExpiringStorage) evictExpired(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.expiry.Len() > 0 && !s.expiry[0].expiry.After(now) {
+		e := heap.Pop(&s.expiry).(expiryItem)
+		if current, ok := s.expiryAt[e.location]; ok && current.Equal(e.expiry) {
+			delete(s.items, e.location)
+			delete(s.expiryAt, e.location)
+		}
+	}
+}
+
+// StartGC starts a goroutine that evicts expired items every interval.
+// It is a no-op if GC is already running.
+func (s *memExpiringStorage) StartGC(interval time.Duration) {
+	s.mu.Lock()
+	if s.stop != nil {
+		s.mu.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	s.stop = stop
+	s.mu.Unlock()
+
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case now := <-t.C:
+				s.evictExpired(now)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// StopGC stops the goroutine started by StartGC, if one is running.
+func (s *memExpiringStorage) StopGC() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	s.stop = nil
+}
+
+// expiryItem is an entry in expiryHeap.
+type expiryItem struct {
+	location string
+	expiry   time.Time
+}
+
+// expiryHeap is a container/heap.Interface ordering expiryItems by
+// ascending expiry time, so that the earliest expiry is always at the
+// root.
+type expiryHeap []expiryItem
+
+func (h expiryHeap) Len() int            { return len(h) }
+func (h expiryHeap) Less(i, j int) bool  { return h[i].expiry.Before(h[j].expiry) }
+func (h expiryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x interface{}) { *h = append(*h, x.(expiryItem)) }
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
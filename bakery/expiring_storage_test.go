@@ -0,0 +1,51 @@
+package bakery
+
+import (
+	"testing"
+	"time"
+)
+
+// TestMemExpiringStorageLazyEviction is the regression test for Get
+// returning an item forever past its expiry when StartGC was never
+// called, contradicting NewMemExpiringStorage's documented behavior.
+func TestMemExpiringStorageLazyEviction(t *testing.T) {
+	s := NewMemExpiringStorage()
+	if err := s.PutWithExpiry("foo", "bar", time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("PutWithExpiry: %v", err)
+	}
+	if _, err := s.Get("foo"); err != ErrNotFound {
+		t.Fatalf("Get on expired item: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemExpiringStorageGetBeforeExpiry(t *testing.T) {
+	s := NewMemExpiringStorage()
+	if err := s.PutWithExpiry("foo", "bar", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("PutWithExpiry: %v", err)
+	}
+	if v, err := s.Get("foo"); err != nil || v != "bar" {
+		t.Fatalf("Get: got (%q, %v), want (\"bar\", nil)", v, err)
+	}
+}
+
+func TestMemExpiringStorageStartStopGC(t *testing.T) {
+	s := NewMemExpiringStorage()
+	if err := s.PutWithExpiry("foo", "bar", time.Now().Add(10*time.Millisecond)); err != nil {
+		t.Fatalf("PutWithExpiry: %v", err)
+	}
+	gc := s.(*memExpiringStorage)
+	gc.StartGC(5 * time.Millisecond)
+	defer gc.StopGC()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		gc.mu.Lock()
+		_, stillThere := gc.items["foo"]
+		gc.mu.Unlock()
+		if !stillThere {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("background GC did not evict the expired item in time")
+}
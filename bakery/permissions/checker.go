@@ -0,0 +1,90 @@
+package permissions
+
+import (
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon.v1"
+
+	"github.com/flynn/macaroon-bakery/bakery"
+	"github.com/flynn/macaroon-bakery/bakery/checkers"
+)
+
+// ErrPermissionDenied is returned when a macaroon's granted permissions
+// do not cover the permissions required for a request.
+var ErrPermissionDenied = errgo.New("permission denied")
+
+// Checker is a checkers.Checker that recognises "permissions" caveats
+// (see NewCaveat) and records the permissions they grant, so that the
+// caller can check the result against what a request requires once
+// verification as a whole has succeeded. Every caveat must hold, so each
+// further "permissions" caveat narrows the granted set to its
+// intersection with what the earlier ones granted (see Intersect). Use
+// Verify rather than this type directly unless you need to combine it
+// with CheckAny.
+type Checker struct {
+	granted Set
+	// seen records whether any "permissions" caveat has been checked.
+	seen bool
+}
+
+// CheckFirstPartyCaveat implements checkers.Checker. It recognises only
+// "permissions" caveats; compose it with checkers.New (or multiChecker,
+// via Verify) to also check other caveat kinds.
+func (c *Checker) CheckFirstPartyCaveat(caveat string) error {
+	cond, arg, err := checkers.ParseCaveat(caveat)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if cond != CondPermissions {
+		return checkers.ErrCaveatNotRecognized
+	}
+	perms, err := decodeSet(arg)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if c.seen {
+		c.granted = Intersect(c.granted, perms)
+	} else {
+		c.granted = perms
+		c.seen = true
+	}
+	return nil
+}
+
+// Granted returns the permissions granted by every "permissions" caveat
+// the Checker has checked so far: the intersection of their sets.
+func (c *Checker) Granted() Set {
+	return c.granted
+}
+
+// multiChecker tries each of its checkers in turn, returning the result
+// (success or failure) of the first one that recognises the caveat, or
+// checkers.ErrCaveatNotRecognized if none of them recognise it.
+type multiChecker []checkers.Checker
+
+func (cs multiChecker) CheckFirstPartyCaveat(caveat string) error {
+	var err error
+	for _, c := range cs {
+		err = c.CheckFirstPartyCaveat(caveat)
+		if err == nil || errgo.Cause(err) != checkers.ErrCaveatNotRecognized {
+			return err
+		}
+	}
+	return err
+}
+
+// Verify checks that ms verifies correctly against svc using other to
+// check any non-permissions caveats, and that the permissions it
+// granted are sufficient to cover required. It returns the full set of
+// permissions the macaroon granted.
+func Verify(svc *bakery.Service, ms macaroon.Slice, other checkers.Checker, required ...Permission) (Set, error) {
+	declared := checkers.InferDeclared(ms)
+	permChecker := &Checker{}
+	checker := checkers.New(declared, multiChecker{permChecker, other})
+	if err := svc.Check(ms, checker); err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	if !permChecker.Granted().Allows(required...) {
+		return nil, errgo.Mask(ErrPermissionDenied, errgo.Is(ErrPermissionDenied))
+	}
+	return permChecker.Granted(), nil
+}
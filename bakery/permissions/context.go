@@ -0,0 +1,28 @@
+package permissions
+
+import (
+	"golang.org/x/net/context"
+)
+
+// grantedKey is the context key under which the permissions granted to
+// the current request are stored by RequirePermission.
+type grantedKey struct{}
+
+// NewContext returns ctx with granted attached, such that
+// HasPermission and FromContext can retrieve it.
+func NewContext(ctx context.Context, granted Set) context.Context {
+	return context.WithValue(ctx, grantedKey{}, granted)
+}
+
+// FromContext returns the permissions granted to the request ctx was
+// derived from, or nil if none were attached.
+func FromContext(ctx context.Context) Set {
+	granted, _ := ctx.Value(grantedKey{}).(Set)
+	return granted
+}
+
+// HasPermission reports whether the request ctx was derived from was
+// granted p.
+func HasPermission(ctx context.Context, p Permission) bool {
+	return FromContext(ctx).Allows(p)
+}
@@ -0,0 +1,52 @@
+package permissions
+
+import (
+	"net/http"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"gopkg.in/macaroon.v1"
+
+	"github.com/flynn/macaroon-bakery/bakery"
+	"github.com/flynn/macaroon-bakery/bakery/checkers"
+	"github.com/flynn/macaroon-bakery/grpcbakery"
+)
+
+// RequirePermission returns a grpc.UnaryServerInterceptor that, in
+// addition to the usual macaroon checks performed by checker, requires
+// that the macaroon grant every permission in required. On success, the
+// granted permission set is made available to the handler via
+// FromContext/HasPermission.
+func RequirePermission(svc *bakery.Service, checker checkers.Checker, required ...Permission) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ms, err := grpcbakery.MacaroonsFromIncomingContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		granted, err := Verify(svc, ms, checker, required...)
+		if err != nil {
+			return nil, err
+		}
+		return handler(NewContext(ctx, granted), req)
+	}
+}
+
+// RequirePermissionHandler wraps next with an http.Handler that performs
+// the same check as RequirePermission, reading the caller's macaroon
+// slice from req via extractMacaroons (typically
+// httpbakery.RequestMacaroons or similar).
+func RequirePermissionHandler(svc *bakery.Service, checker checkers.Checker, extractMacaroons func(*http.Request) (macaroon.Slice, error), required []Permission, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ms, err := extractMacaroons(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		granted, err := Verify(svc, ms, checker, required...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, req.WithContext(NewContext(req.Context(), granted)))
+	})
+}
@@ -0,0 +1,81 @@
+package permissions
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"gopkg.in/macaroon.v1"
+
+	"github.com/flynn/macaroon-bakery/bakery"
+	"github.com/flynn/macaroon-bakery/bakery/checkers"
+)
+
+// noopChecker recognises no caveats of its own; it lets Verify exercise
+// only the permissions.Checker logic.
+type noopChecker struct{}
+
+func (noopChecker) CheckFirstPartyCaveat(caveat string) error {
+	return checkers.ErrCaveatNotRecognized
+}
+
+func encodeForMetadata(t *testing.T, ms macaroon.Slice) string {
+	t.Helper()
+	data, err := json.Marshal(ms)
+	if err != nil {
+		t.Fatalf("cannot marshal macaroons: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// TestRequirePermissionAuthorizesIncomingMacaroon is the regression test
+// for RequirePermission reading the macaroon from the wrong (client
+// outgoing) context key: it must authorize a macaroon that actually
+// arrived as incoming request metadata.
+func TestRequirePermissionAuthorizesIncomingMacaroon(t *testing.T) {
+	svc, err := bakery.NewService(bakery.NewServiceParams{
+		Location: "test",
+		Store:    bakery.NewMemExpiringStorage(),
+	})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	m, err := NewMacaroonForPermissions(svc, Set{{Entity: "doc", Action: "read"}}, nil)
+	if err != nil {
+		t.Fatalf("NewMacaroonForPermissions: %v", err)
+	}
+	encoded := encodeForMetadata(t, macaroon.Slice{m})
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("macaroon", encoded))
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+
+	handlerCalled := false
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		handlerCalled = true
+		if !HasPermission(ctx, Permission{Entity: "doc", Action: "read"}) {
+			t.Fatalf("HasPermission is false inside the handler")
+		}
+		return "ok", nil
+	}
+
+	resp, err := RequirePermission(svc, noopChecker{}, Permission{Entity: "doc", Action: "read"})(ctx, "req", info, handler)
+	if err != nil {
+		t.Fatalf("RequirePermission denied a correctly permitted request: %v", err)
+	}
+	if !handlerCalled {
+		t.Fatalf("handler was never called")
+	}
+	if resp != "ok" {
+		t.Fatalf("unexpected response: %v", resp)
+	}
+
+	handler2 := func(ctx context.Context, req interface{}) (interface{}, error) {
+		t.Fatalf("handler should not be called for an unauthorized request")
+		return nil, nil
+	}
+	if _, err := RequirePermission(svc, noopChecker{}, Permission{Entity: "doc", Action: "write"})(ctx, "req", info, handler2); err == nil {
+		t.Fatalf("RequirePermission allowed a request lacking the required permission")
+	}
+}
@@ -0,0 +1,189 @@
+// Package permissions formalizes a capability model on top of the
+// bakery's existing caveat and checker plumbing: a macaroon can carry a
+// "permissions" first-party caveat that grants it a set of
+// entity/action pairs, and a Checker verifies that a macaroon carries
+// (at least) the permissions required for a given request.
+package permissions
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"path"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon.v1"
+
+	"github.com/flynn/macaroon-bakery/bakery"
+	"github.com/flynn/macaroon-bakery/bakery/checkers"
+)
+
+// EntityCustomURI is a special Permission.Entity value denoting a grant
+// scoped to an HTTP-style path rather than a named entity. When used,
+// Permission.Action holds the path, which may end in "*" to grant every
+// path under that prefix.
+const EntityCustomURI = "uri"
+
+// CondPermissions is the first-party caveat condition used to encode a
+// granted permission set.
+const CondPermissions = "permissions"
+
+// Permission identifies something a macaroon holder may do: performing
+// Action against Entity. The special value "*" for either field grants
+// every entity or every action respectively.
+type Permission struct {
+	Entity string `json:"entity"`
+	Action string `json:"action"`
+}
+
+// includes reports whether p, a granted permission, covers the
+// permission required, a requirement.
+func (p Permission) includes(required Permission) bool {
+	if p.Entity == EntityCustomURI && required.Entity == EntityCustomURI {
+		return uriMatch(p.Action, required.Action)
+	}
+	if p.Entity != "*" && p.Entity != required.Entity {
+		return false
+	}
+	return p.Action == "*" || p.Action == required.Action
+}
+
+// uriMatch reports whether the path uri matches the granted pattern,
+// which may end in "*" to match every path sharing that prefix.
+func uriMatch(pattern, uri string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(uri, strings.TrimSuffix(pattern, "*"))
+	}
+	ok, err := path.Match(pattern, uri)
+	return err == nil && ok
+}
+
+// Set is a set of granted permissions.
+type Set []Permission
+
+// Allows reports whether the permissions in s are sufficient to satisfy
+// every permission in required.
+func (s Set) Allows(required ...Permission) bool {
+	for _, req := range required {
+		granted := false
+		for _, p := range s {
+			if p.includes(req) {
+				granted = true
+				break
+			}
+		}
+		if !granted {
+			return false
+		}
+	}
+	return true
+}
+
+// NewCaveat returns a first-party caveat that grants perms when added to
+// a macaroon.
+func NewCaveat(perms Set) (checkers.Caveat, error) {
+	encoded, err := encodeSet(perms)
+	if err != nil {
+		return checkers.Caveat{}, errgo.Mask(err)
+	}
+	return checkers.Caveat{
+		Condition: CondPermissions + " " + encoded,
+	}, nil
+}
+
+// NewMacaroonForPermissions mints a new macaroon from svc that grants
+// perms, in addition to any extra caveats supplied by the caller.
+func NewMacaroonForPermissions(svc *bakery.Service, perms Set, extra []checkers.Caveat) (*macaroon.Macaroon, error) {
+	cav, err := NewCaveat(perms)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	caveats := append([]checkers.Caveat{cav}, extra...)
+	return svc.NewMacaroon("", nil, caveats)
+}
+
+func encodeSet(perms Set) (string, error) {
+	data, err := json.Marshal(perms)
+	if err != nil {
+		return "", errgo.Notef(err, "cannot marshal permissions")
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+func decodeSet(encoded string) (Set, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot base64-decode permissions")
+	}
+	var perms Set
+	if err := json.Unmarshal(data, &perms); err != nil {
+		return nil, errgo.Notef(err, "cannot unmarshal permissions")
+	}
+	return perms, nil
+}
+
+// Intersect returns the permissions that are implied by both a and b.
+// It is useful when a client wants to attenuate an existing macaroon
+// with AddCaveat: the intersection of the macaroon's current
+// permissions and the desired, more restrictive set is always safe to
+// grant, since it can never exceed what the macaroon already allows.
+func Intersect(a, b Set) Set {
+	seen := make(map[Permission]bool)
+	var result Set
+	for _, pa := range a {
+		for _, pb := range b {
+			p, ok := intersectPermission(pa, pb)
+			if !ok || seen[p] {
+				continue
+			}
+			seen[p] = true
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// intersectPermission returns the narrowest permission implied by both
+// pa and pb, if they overlap at all. A plain wildcard field ("*")
+// narrows to whatever the other side specifies, so e.g. {"*", "read"}
+// and {"foo", "*"} intersect to {"foo", "read"} rather than being
+// treated as disjoint.
+func intersectPermission(pa, pb Permission) (Permission, bool) {
+	if pa.Entity == EntityCustomURI || pb.Entity == EntityCustomURI {
+		// Synthesizing the intersection of two path globs isn't
+		// supported; only fall back to the case where one grant
+		// wholly includes the other.
+		if pa.includes(pb) {
+			return pb, true
+		}
+		if pb.includes(pa) {
+			return pa, true
+		}
+		return Permission{}, false
+	}
+	entity, ok := intersectField(pa.Entity, pb.Entity)
+	if !ok {
+		return Permission{}, false
+	}
+	action, ok := intersectField(pa.Action, pb.Action)
+	if !ok {
+		return Permission{}, false
+	}
+	return Permission{Entity: entity, Action: action}, true
+}
+
+// intersectField returns the narrower of two Permission fields that may
+// each be the "*" wildcard, or false if neither is a wildcard and they
+// differ.
+func intersectField(a, b string) (string, bool) {
+	switch {
+	case a == "*":
+		return b, true
+	case b == "*":
+		return a, true
+	case a == b:
+		return a, true
+	default:
+		return "", false
+	}
+}
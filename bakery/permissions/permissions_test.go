@@ -0,0 +1,94 @@
+package permissions
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestIntersectWildcardCrossing is the regression test for Intersect
+// returning an empty set when two grants each wildcard a different
+// field rather than synthesizing the narrower permission they actually
+// agree on.
+func TestIntersectWildcardCrossing(t *testing.T) {
+	a := Set{{Entity: "*", Action: "read"}}
+	b := Set{{Entity: "foo", Action: "*"}}
+	got := Intersect(a, b)
+	want := Set{{Entity: "foo", Action: "read"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Intersect(%v, %v) = %v, want %v", a, b, got, want)
+	}
+}
+
+func TestIntersectWholeInclusion(t *testing.T) {
+	a := Set{{Entity: "*", Action: "*"}}
+	b := Set{{Entity: "foo", Action: "read"}}
+	got := Intersect(a, b)
+	want := Set{{Entity: "foo", Action: "read"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Intersect(%v, %v) = %v, want %v", a, b, got, want)
+	}
+}
+
+func TestIntersectDisjoint(t *testing.T) {
+	a := Set{{Entity: "foo", Action: "read"}}
+	b := Set{{Entity: "bar", Action: "read"}}
+	if got := Intersect(a, b); len(got) != 0 {
+		t.Fatalf("Intersect(%v, %v) = %v, want empty", a, b, got)
+	}
+}
+
+// TestIntersectDedupes checks that a granter with two entries that both
+// overlap the same permission on the other side only contributes it
+// once.
+func TestIntersectDedupes(t *testing.T) {
+	a := Set{
+		{Entity: "*", Action: "read"},
+		{Entity: "foo", Action: "*"},
+	}
+	b := Set{{Entity: "foo", Action: "read"}}
+	got := Intersect(a, b)
+	want := Set{{Entity: "foo", Action: "read"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Intersect(%v, %v) = %v, want %v", a, b, got, want)
+	}
+}
+
+func TestIntersectEntityCustomURI(t *testing.T) {
+	a := Set{{Entity: EntityCustomURI, Action: "/widgets/*"}}
+	b := Set{{Entity: EntityCustomURI, Action: "/widgets/1"}}
+	got := Intersect(a, b)
+	want := Set{{Entity: EntityCustomURI, Action: "/widgets/1"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Intersect(%v, %v) = %v, want %v", a, b, got, want)
+	}
+}
+
+func TestUriMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		uri     string
+		want    bool
+	}{
+		{"/widgets/*", "/widgets/1", true},
+		{"/widgets/*", "/widgets/1/parts", true},
+		{"/widgets/*", "/gadgets/1", false},
+		{"/widgets/1", "/widgets/1", true},
+		{"/widgets/1", "/widgets/2", false},
+		{"/widgets/?", "/widgets/1", true},
+	}
+	for _, c := range cases {
+		if got := uriMatch(c.pattern, c.uri); got != c.want {
+			t.Errorf("uriMatch(%q, %q) = %v, want %v", c.pattern, c.uri, got, c.want)
+		}
+	}
+}
+
+func TestSetAllowsEntityCustomURI(t *testing.T) {
+	s := Set{{Entity: EntityCustomURI, Action: "/widgets/*"}}
+	if !s.Allows(Permission{Entity: EntityCustomURI, Action: "/widgets/1"}) {
+		t.Fatalf("expected /widgets/1 to be allowed under /widgets/*")
+	}
+	if s.Allows(Permission{Entity: EntityCustomURI, Action: "/gadgets/1"}) {
+		t.Fatalf("expected /gadgets/1 to be denied under /widgets/*")
+	}
+}
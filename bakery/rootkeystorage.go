@@ -0,0 +1,292 @@
+package bakery
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"sync"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+
+	"gopkg.in/errgo.v1"
+)
+
+// ErrStoreLocked is returned by an EncryptedStorage's Put and Get methods
+// when the store has not yet been unlocked with the correct passphrase.
+var ErrStoreLocked = errgo.New("root key store is locked")
+
+// ErrWrongPassword is returned by Unlock and ChangePassword when the
+// supplied password does not match the store's current password (the
+// one it was first unlocked with, or the latest one set by
+// ChangePassword or Rotate).
+var ErrWrongPassword = errgo.New("wrong password")
+
+const (
+	saltSize  = 32
+	nonceSize = 24
+	keySize   = 32
+
+	saltLocation   = "_salt"
+	headerLocation = "_root_key_header"
+)
+
+// KVStore is the minimal key/value persistence interface that
+// EncryptedStorage needs from an underlying database such as BoltDB.
+// Keys and values are opaque byte strings.
+type KVStore interface {
+	Put(key, value []byte) error
+	Get(key []byte) ([]byte, error)
+}
+
+// rootKeyHeader is the on-disk record that lets EncryptedStorage verify
+// a password and recover the store's data-encryption key. Every root
+// key Put to the store is sealed under the data-encryption key, which
+// is generated once and never changes; only the copy of it sealed here,
+// under the password-derived key, changes on Rotate/ChangePassword.
+// This means rotating the password is an O(1) operation on this single
+// record rather than requiring every previously stored root key to be
+// re-wrapped.
+type rootKeyHeader struct {
+	// RootKeyID identifies this generation of the wrapped
+	// data-encryption key. It changes on every Rotate, so that stale
+	// cached headers can be recognised.
+	RootKeyID string
+	// SealedKey is the data-encryption key, sealed under the
+	// password-derived key.
+	SealedKey []byte
+}
+
+// EncryptedStorage is a Storage implementation that wraps an arbitrary
+// KVStore and encrypts every root key it stores with a data-encryption
+// key that is itself sealed under a key derived from a user-supplied
+// passphrase (see rootKeyHeader). It starts locked: Put and Get both
+// return ErrStoreLocked until Unlock has been called with the correct
+// password.
+//
+// This lets a Service persist macaroon root keys on disk (or in any
+// other KV store) without ever holding the plaintext keys at rest.
+type EncryptedStorage struct {
+	kv   KVStore
+	salt []byte
+
+	// mu guards dek: Put and Get hold it for the duration of the
+	// seal/open call they make with it, and Unlock/Rotate/Close hold
+	// it while replacing or wiping it, so that a rotation or Close
+	// can never run concurrently with a seal/open that is still
+	// reading the same key array.
+	mu sync.Mutex
+	// dek is the data-encryption key used to seal and open every
+	// Put/Get record. It is nil while the store is locked, and does
+	// not change across password rotations.
+	dek *[keySize]byte
+}
+
+// NewEncryptedStorage returns a new EncryptedStorage backed by kv. If kv
+// does not already hold a salt, one is created. The returned store is
+// locked; call Unlock before using it with a Service.
+func NewEncryptedStorage(kv KVStore) (*EncryptedStorage, error) {
+	s := &EncryptedStorage{kv: kv}
+	salt, err := kv.Get([]byte(saltLocation))
+	if err != nil && errgo.Cause(err) != ErrNotFound {
+		return nil, errgo.Notef(err, "cannot read salt")
+	}
+	if salt == nil {
+		salt = make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, errgo.Notef(err, "cannot generate salt")
+		}
+		if err := kv.Put([]byte(saltLocation), salt); err != nil {
+			return nil, errgo.Notef(err, "cannot store salt")
+		}
+	}
+	s.salt = salt
+	return s, nil
+}
+
+// deriveKey derives a key from password using scrypt.
+func (s *EncryptedStorage) deriveKey(password string) (*[keySize]byte, error) {
+	derived, err := scrypt.Key([]byte(password), s.salt, 1<<15, 8, 1, keySize)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot derive key")
+	}
+	var key [keySize]byte
+	copy(key[:], derived)
+	return &key, nil
+}
+
+// Unlock unlocks the store with password. It returns ErrWrongPassword if
+// password does not match the store's current password.
+func (s *EncryptedStorage) Unlock(password string) error {
+	kek, err := s.deriveKey(password)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	raw, err := s.kv.Get([]byte(headerLocation))
+	if err != nil && errgo.Cause(err) != ErrNotFound {
+		return errgo.Notef(err, "cannot read root key header")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if raw == nil {
+		// First unlock: generate a fresh data-encryption key and
+		// wrap it under the password-derived key.
+		var dek [keySize]byte
+		if _, err := rand.Read(dek[:]); err != nil {
+			return errgo.Notef(err, "cannot generate data-encryption key")
+		}
+		if err := s.writeHeader(kek, &dek); err != nil {
+			return errgo.Mask(err)
+		}
+		s.setDEK(&dek)
+		return nil
+	}
+	var hdr rootKeyHeader
+	if err := json.Unmarshal(raw, &hdr); err != nil {
+		return errgo.Notef(err, "cannot unmarshal root key header")
+	}
+	plain, err := open(hdr.SealedKey, kek)
+	if err != nil || len(plain) != keySize {
+		return ErrWrongPassword
+	}
+	var dek [keySize]byte
+	copy(dek[:], plain)
+	s.setDEK(&dek)
+	return nil
+}
+
+// writeHeader seals dek under kek and stores the resulting
+// rootKeyHeader, under a freshly generated RootKeyID.
+func (s *EncryptedStorage) writeHeader(kek, dek *[keySize]byte) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return errgo.Notef(err, "cannot generate root key id")
+	}
+	sealedKey, err := seal(dek[:], kek)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	data, err := json.Marshal(rootKeyHeader{
+		RootKeyID: string(id),
+		SealedKey: sealedKey,
+	})
+	if err != nil {
+		return errgo.Notef(err, "cannot marshal root key header")
+	}
+	if err := s.kv.Put([]byte(headerLocation), data); err != nil {
+		return errgo.Notef(err, "cannot store root key header")
+	}
+	return nil
+}
+
+// setDEK installs dek as the current data-encryption key, wiping any
+// previously held one first. The caller must hold s.mu.
+func (s *EncryptedStorage) setDEK(dek *[keySize]byte) {
+	s.wipeDEK()
+	s.dek = dek
+}
+
+// wipeDEK zeros the current data-encryption key, if any. The caller
+// must hold s.mu.
+func (s *EncryptedStorage) wipeDEK() {
+	if s.dek != nil {
+		for i := range s.dek {
+			s.dek[i] = 0
+		}
+		s.dek = nil
+	}
+}
+
+// ChangePassword re-wraps the store's data-encryption key under new,
+// replacing old. Every root key previously stored with Put remains
+// sealed under the same data-encryption key, so nothing is lost. It
+// returns ErrWrongPassword if old does not unlock the store.
+func (s *EncryptedStorage) ChangePassword(old, new string) error {
+	if err := s.Unlock(old); err != nil {
+		return errgo.Mask(err, errgo.Is(ErrWrongPassword))
+	}
+	return s.Rotate(new)
+}
+
+// Rotate re-wraps the store's data-encryption key under a key derived
+// from newPassword. Because every root key is sealed under the
+// data-encryption key rather than directly under the password, this is
+// an O(1) operation that never needs to touch, re-encrypt or risk
+// losing any previously stored root key. The store must already be
+// unlocked.
+func (s *EncryptedStorage) Rotate(newPassword string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dek == nil {
+		return ErrStoreLocked
+	}
+	newKek, err := s.deriveKey(newPassword)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return s.writeHeader(newKek, s.dek)
+}
+
+// Put implements Storage.Put, sealing item under the store's
+// data-encryption key before writing it to the underlying KVStore.
+func (s *EncryptedStorage) Put(location, item string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dek == nil {
+		return ErrStoreLocked
+	}
+	sealed, err := seal([]byte(item), s.dek)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return s.kv.Put([]byte(location), sealed)
+}
+
+// Get implements Storage.Get, opening the sealed record stored at
+// location under the store's data-encryption key.
+func (s *EncryptedStorage) Get(location string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dek == nil {
+		return "", ErrStoreLocked
+	}
+	sealed, err := s.kv.Get([]byte(location))
+	if err != nil {
+		return "", errgo.Mask(err, errgo.Is(ErrNotFound))
+	}
+	plain, err := open(sealed, s.dek)
+	if err != nil {
+		return "", errgo.Notef(err, "cannot decrypt item at %q", location)
+	}
+	return string(plain), nil
+}
+
+// Close zeros the in-memory data-encryption key so that it does not
+// linger in the process's memory after the store is no longer needed.
+func (s *EncryptedStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.wipeDEK()
+	return nil
+}
+
+func seal(plain []byte, key *[keySize]byte) ([]byte, error) {
+	var nonce [nonceSize]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, errgo.Notef(err, "cannot generate nonce")
+	}
+	sealed := secretbox.Seal(nonce[:], plain, &nonce, key)
+	return sealed, nil
+}
+
+func open(sealed []byte, key *[keySize]byte) ([]byte, error) {
+	if len(sealed) < nonceSize {
+		return nil, errgo.New("encrypted record too short")
+	}
+	var nonce [nonceSize]byte
+	copy(nonce[:], sealed[:nonceSize])
+	plain, ok := secretbox.Open(nil, sealed[nonceSize:], &nonce, key)
+	if !ok {
+		return nil, errgo.New("cannot decrypt record")
+	}
+	return plain, nil
+}
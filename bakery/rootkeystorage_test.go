@@ -0,0 +1,159 @@
+package bakery
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"gopkg.in/errgo.v1"
+)
+
+// memKVStore is a trivial in-memory KVStore for testing EncryptedStorage.
+type memKVStore map[string][]byte
+
+func (kv memKVStore) Put(key, value []byte) error {
+	kv[string(key)] = append([]byte(nil), value...)
+	return nil
+}
+
+func (kv memKVStore) Get(key []byte) ([]byte, error) {
+	v, ok := kv[string(key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return v, nil
+}
+
+func TestEncryptedStoragePutGet(t *testing.T) {
+	s, err := NewEncryptedStorage(make(memKVStore))
+	if err != nil {
+		t.Fatalf("NewEncryptedStorage: %v", err)
+	}
+	if _, err := s.Get("foo"); errgo.Cause(err) != ErrStoreLocked {
+		t.Fatalf("Get on locked store: got %v, want ErrStoreLocked", err)
+	}
+	if err := s.Unlock("hunter2"); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if err := s.Put("foo", "bar"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if v, err := s.Get("foo"); err != nil || v != "bar" {
+		t.Fatalf("Get: got (%q, %v), want (\"bar\", nil)", v, err)
+	}
+}
+
+func TestEncryptedStorageWrongPassword(t *testing.T) {
+	kv := make(memKVStore)
+	s, err := NewEncryptedStorage(kv)
+	if err != nil {
+		t.Fatalf("NewEncryptedStorage: %v", err)
+	}
+	if err := s.Unlock("hunter2"); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+
+	s2, err := NewEncryptedStorage(kv)
+	if err != nil {
+		t.Fatalf("NewEncryptedStorage: %v", err)
+	}
+	if err := s2.Unlock("wrong password"); errgo.Cause(err) != ErrWrongPassword {
+		t.Fatalf("Unlock with wrong password: got %v, want ErrWrongPassword", err)
+	}
+}
+
+// TestEncryptedStorageRotatePreservesExistingItems is the regression
+// test for the data loss a previous version of Rotate could cause:
+// items stored before a password change must still be readable
+// afterwards.
+func TestEncryptedStorageRotatePreservesExistingItems(t *testing.T) {
+	kv := make(memKVStore)
+	s, err := NewEncryptedStorage(kv)
+	if err != nil {
+		t.Fatalf("NewEncryptedStorage: %v", err)
+	}
+	if err := s.Unlock("old password"); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if err := s.Put("macaroon-1", "root-key-1"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	if err := s.ChangePassword("old password", "new password"); err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+	if v, err := s.Get("macaroon-1"); err != nil || v != "root-key-1" {
+		t.Fatalf("Get after ChangePassword: got (%q, %v), want (\"root-key-1\", nil)", v, err)
+	}
+
+	// A fresh store pointed at the same kv must unlock with the new
+	// password and still see the item.
+	s2, err := NewEncryptedStorage(kv)
+	if err != nil {
+		t.Fatalf("NewEncryptedStorage: %v", err)
+	}
+	if err := s2.Unlock("new password"); err != nil {
+		t.Fatalf("Unlock with new password: %v", err)
+	}
+	if v, err := s2.Get("macaroon-1"); err != nil || v != "root-key-1" {
+		t.Fatalf("Get from fresh store: got (%q, %v), want (\"root-key-1\", nil)", v, err)
+	}
+	if errgo.Cause(s2.Unlock("old password")) != ErrWrongPassword {
+		t.Fatalf("old password should no longer unlock the store")
+	}
+}
+
+// TestEncryptedStorageConcurrentAccess is a -race regression test:
+// Put/Get used to read s.dek with no locking at all while Rotate and
+// Close could concurrently replace or zero it out from under them.
+func TestEncryptedStorageConcurrentAccess(t *testing.T) {
+	s, err := NewEncryptedStorage(make(memKVStore))
+	if err != nil {
+		t.Fatalf("NewEncryptedStorage: %v", err)
+	}
+	if err := s.Unlock("hunter2"); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			location := fmt.Sprintf("macaroon-%d", i)
+			// Errors are ignored: this test only checks that Put and
+			// Get running concurrently with Rotate neither race nor
+			// panic.
+			if err := s.Put(location, "root-key"); err != nil {
+				return
+			}
+			s.Get(location)
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s.Rotate(fmt.Sprintf("password-%d", i))
+		}(i)
+	}
+	wg.Wait()
+	s.Close()
+}
+
+func TestEncryptedStorageClose(t *testing.T) {
+	s, err := NewEncryptedStorage(make(memKVStore))
+	if err != nil {
+		t.Fatalf("NewEncryptedStorage: %v", err)
+	}
+	if err := s.Unlock("hunter2"); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := s.Put("foo", "bar"); errgo.Cause(err) != ErrStoreLocked {
+		t.Fatalf("Put after Close: got %v, want ErrStoreLocked", err)
+	}
+}
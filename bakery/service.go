@@ -7,6 +7,7 @@ package bakery
 import (
 	"crypto/rand"
 	"fmt"
+	"io"
 	"strings"
 
 	"github.com/juju/loggo"
@@ -24,9 +25,11 @@ type Service struct {
 	location string
 	store    *storage
 	checker  FirstPartyChecker
+	checkers *checkerRegistry
 	encoder  *boxEncoder
 	key      *KeyPair
 	locator  PublicKeyLocator
+	version  Version
 }
 
 // NewServiceParams holds the parameters for a NewService call.
@@ -50,14 +53,26 @@ type NewServiceParams struct {
 	// adding a third-party caveat.
 	// It may be nil, in which case, no third-party caveats can be created.
 	Locator PublicKeyLocator
+
+	// MacaroonVersion selects the wire version that macaroons minted
+	// by this service carry their caveat IDs in. It may be zero, in
+	// which case Version1 is used, matching earlier releases of this
+	// package.
+	MacaroonVersion Version
 }
 
 // NewService returns a new service that can mint new
 // macaroons and store their associated root keys.
 func NewService(p NewServiceParams) (*Service, error) {
+	version := p.MacaroonVersion
+	if version == 0 {
+		version = Version1
+	}
 	svc := &Service{
 		location: p.Location,
 		locator:  p.Locator,
+		checkers: newCheckerRegistry(),
+		version:  version,
 	}
 	if p.Store != nil {
 		svc.store = &storage{p.Store}
@@ -78,6 +93,26 @@ func NewService(p NewServiceParams) (*Service, error) {
 	return svc, nil
 }
 
+// Close zeros the service's long-lived private key so that it does not
+// linger in the process's memory, and closes the service's store, if
+// the store implements io.Closer (as EncryptedStorage does), so that it
+// can zero any root keys it holds in memory too. The Service must not
+// be used again after Close.
+func (svc *Service) Close() error {
+	if svc.key != nil {
+		key := svc.key.Private.Key
+		for i := range key {
+			key[i] = 0
+		}
+		svc.key.Private.Key = key
+		svc.key = nil
+	}
+	if c, ok := svc.Store().(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
 // Store returns the store used by the service.
 func (svc *Service) Store() Storage {
 	if svc.store != nil {
@@ -228,18 +263,16 @@ func (svc *Service) NewMacaroon(id string, rootKey []byte, caveats []checkers.Ca
 	if err != nil {
 		return nil, fmt.Errorf("cannot bake macaroon: %v", err)
 	}
+	setMacaroonVersion(m.Id(), svc.version)
 	for _, cav := range caveats {
 		if err := svc.AddCaveat(m, cav); err != nil {
 			return nil, errgo.Notef(err, "cannot add caveat")
 		}
 	}
 	if svc.store != nil {
-		// TODO look at the caveats for expiry time and associate
-		// that with the storage item so that the storage can
-		// garbage collect it at an appropriate time.
-		if err := svc.store.Put(m.Id(), &storageItem{
+		if err := svc.store.putWithExpiry(m.Id(), &storageItem{
 			RootKey: rootKey,
-		}); err != nil {
+		}, earliestExpiry(caveats)); err != nil {
 			return nil, fmt.Errorf("cannot save macaroon to store: %v", err)
 		}
 	}
@@ -296,7 +329,7 @@ func (svc *Service) AddCaveat(m *macaroon.Macaroon, cav checkers.Caveat) error {
 	if err != nil {
 		return errgo.Notef(err, "cannot generate third party secret")
 	}
-	id, err := svc.encoder.encodeCaveatId(cav.Condition, rootKey, thirdPartyPub)
+	id, err := svc.encoder.encodeCaveatId(cav.Condition, rootKey, thirdPartyPub, svc.version)
 	if err != nil {
 		return errgo.Notef(err, "cannot create third party caveat id at %q", cav.Location)
 	}
@@ -340,6 +373,10 @@ func Discharge(key *KeyPair, checker ThirdPartyChecker, id string) (*macaroon.Ma
 	if err != nil {
 		return nil, nil, errgo.Mask(err)
 	}
+	// Preserve the version of the caveat id being discharged, so that
+	// the discharge macaroon is encoded consistently with the
+	// macaroon it discharges.
+	setMacaroonVersion(m.Id(), caveatIdVersion(id))
 	return m, caveats, nil
 }
 
@@ -0,0 +1,115 @@
+package bakery
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sync"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon.v1"
+)
+
+// Version identifies a wire format that a minted macaroon's caveat IDs
+// may be encoded in, so that this service can interoperate with other
+// parts of the macaroon ecosystem (lnd, juju, superfly) that use the
+// newer v2 binary encoding.
+//
+// gopkg.in/macaroon.v1, which this package is still built on, has no
+// notion of a macaroon version of its own: the macaroon body itself is
+// always v1 JSON. Version instead governs two things this package does
+// control: the format boxEncoder/boxDecoder use for third-party caveat
+// ids (base64 JSON for Version1, raw binary for Version2 - see
+// encodeCaveatId), and the single-byte framing MarshalMacaroons prefixes
+// onto the wire form of a macaroon slice.
+type Version int
+
+const (
+	// Version1 is the default: JSON-encoded, base64 caveat IDs, as
+	// produced by earlier releases of this package.
+	Version1 Version = 1
+
+	// Version2 selects binary caveat IDs - firstPartyPublicKey(32) ||
+	// nonce(24) || ciphertext, with no base64 or JSON framing - for
+	// services that need to talk to v2-only peers. See boxEncoder's
+	// encodeCaveatId and boxDecoder's decodeCaveatId.
+	Version2 Version = 2
+)
+
+// macaroonVersions records the Version a macaroon was minted with (by
+// NewMacaroon) or discharged with (by Discharge), so that code which
+// only has a *macaroon.Macaroon can recover it with VersionOf. It is
+// best-effort: a macaroon that was serialized and
+// reconstituted by a third party will not have an entry, and callers
+// should fall back to Version1 in that case.
+var macaroonVersions = struct {
+	mu sync.Mutex
+	m  map[string]Version
+}{m: make(map[string]Version)}
+
+func setMacaroonVersion(id string, v Version) {
+	macaroonVersions.mu.Lock()
+	defer macaroonVersions.mu.Unlock()
+	macaroonVersions.m[id] = v
+}
+
+// VersionOf returns the Version that m was minted with, as recorded by
+// this process's NewMacaroon or Discharge, or Version1 if none is known.
+func VersionOf(m *macaroon.Macaroon) Version {
+	macaroonVersions.mu.Lock()
+	defer macaroonVersions.mu.Unlock()
+	if v, ok := macaroonVersions.m[m.Id()]; ok {
+		return v
+	}
+	return Version1
+}
+
+// caveatIdVersion guesses the Version a third-party caveat id was
+// encoded with: Version1 ids are base64-encoded JSON, so anything that
+// fails to base64-decode is assumed to be a Version2 binary id.
+func caveatIdVersion(id string) Version {
+	if _, err := base64.StdEncoding.DecodeString(id); err != nil {
+		return Version2
+	}
+	return Version1
+}
+
+// MarshalMacaroons marshals ms for wire transmission using the given
+// version's framing. Version1 produces the usual JSON array; Version2
+// prefixes that JSON with a single version byte. The macaroons' own
+// caveat IDs are already binary-or-not as encoded by boxEncoder when
+// they were minted (see the Version doc comment); this framing byte
+// only tells UnmarshalMacaroons which convention the caveat IDs inside
+// ms use.
+func MarshalMacaroons(ms macaroon.Slice, v Version) ([]byte, error) {
+	data, err := json.Marshal(ms)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot marshal macaroons")
+	}
+	switch v {
+	case Version1:
+		return data, nil
+	case Version2:
+		return append([]byte{byte(Version2)}, data...), nil
+	default:
+		return nil, errgo.Newf("unknown macaroon version %d", v)
+	}
+}
+
+// UnmarshalMacaroons is the inverse of MarshalMacaroons. It autodetects
+// v1 JSON framing (data starts with '[') versus v2 binary framing (data
+// starts with the Version2 byte) by sniffing the first byte.
+func UnmarshalMacaroons(data []byte) (macaroon.Slice, Version, error) {
+	if len(data) == 0 {
+		return nil, 0, errgo.New("no data to unmarshal")
+	}
+	v := Version1
+	if data[0] == byte(Version2) {
+		v = Version2
+		data = data[1:]
+	}
+	var ms macaroon.Slice
+	if err := json.Unmarshal(data, &ms); err != nil {
+		return nil, 0, errgo.Notef(err, "cannot unmarshal macaroons")
+	}
+	return ms, v, nil
+}
@@ -0,0 +1,110 @@
+package bakery
+
+import (
+	"bytes"
+	"testing"
+
+	"gopkg.in/macaroon.v1"
+)
+
+// TestCaveatIdRoundTrip checks that a caveat id encoded with either
+// version is recognised as that version by caveatIdVersion and
+// round-trips through decodeCaveatId, which autodetects whichever of
+// the two wire formats encodeCaveatId used.
+func TestCaveatIdRoundTrip(t *testing.T) {
+	thirdParty, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey (third party): %v", err)
+	}
+	firstParty, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey (first party): %v", err)
+	}
+	encoder := newBoxEncoder(firstParty)
+	decoder := newBoxDecoder(thirdParty)
+
+	rootKey := []byte("a root key shared with the discharger")
+	condition := "allow everything"
+
+	for _, v := range []Version{Version1, Version2} {
+		id, err := encoder.encodeCaveatId(condition, rootKey, &thirdParty.Public, v)
+		if err != nil {
+			t.Fatalf("encodeCaveatId with version %d: %v", v, err)
+		}
+		if gotV := caveatIdVersion(id); gotV != v {
+			t.Fatalf("caveatIdVersion(id) = %d, want %d", gotV, v)
+		}
+		gotKey, gotCondition, err := decoder.decodeCaveatId(id)
+		if err != nil {
+			t.Fatalf("decodeCaveatId of version %d id: %v", v, err)
+		}
+		if !bytes.Equal(gotKey, rootKey) {
+			t.Fatalf("version %d: root key = %q, want %q", v, gotKey, rootKey)
+		}
+		if gotCondition != condition {
+			t.Fatalf("version %d: condition = %q, want %q", v, gotCondition, condition)
+		}
+	}
+}
+
+// TestCaveatIdWrongKeyFails checks that a third party which wasn't the
+// intended recipient cannot open the caveat id, for either version.
+func TestCaveatIdWrongKeyFails(t *testing.T) {
+	intended, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey (intended): %v", err)
+	}
+	other, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey (other): %v", err)
+	}
+	firstParty, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey (first party): %v", err)
+	}
+	encoder := newBoxEncoder(firstParty)
+
+	for _, v := range []Version{Version1, Version2} {
+		id, err := encoder.encodeCaveatId("condition", []byte("root key"), &intended.Public, v)
+		if err != nil {
+			t.Fatalf("encodeCaveatId with version %d: %v", v, err)
+		}
+		if _, _, err := newBoxDecoder(other).decodeCaveatId(id); err == nil {
+			t.Fatalf("version %d: decodeCaveatId unexpectedly succeeded with the wrong key", v)
+		}
+	}
+}
+
+// TestMarshalUnmarshalMacaroonsRoundTrip exercises the MarshalMacaroons
+// / UnmarshalMacaroons framing byte across both versions.
+func TestMarshalUnmarshalMacaroonsRoundTrip(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	svc, err := NewService(NewServiceParams{Location: "here", Key: key})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	m, err := svc.NewMacaroon("", nil, nil)
+	if err != nil {
+		t.Fatalf("NewMacaroon: %v", err)
+	}
+
+	for _, v := range []Version{Version1, Version2} {
+		data, err := MarshalMacaroons(macaroon.Slice{m}, v)
+		if err != nil {
+			t.Fatalf("MarshalMacaroons with version %d: %v", v, err)
+		}
+		ms, gotV, err := UnmarshalMacaroons(data)
+		if err != nil {
+			t.Fatalf("UnmarshalMacaroons of version %d data: %v", v, err)
+		}
+		if gotV != v {
+			t.Fatalf("UnmarshalMacaroons version = %d, want %d", gotV, v)
+		}
+		if len(ms) != 1 || ms[0].Id() != m.Id() {
+			t.Fatalf("UnmarshalMacaroons returned unexpected macaroons: %#v", ms)
+		}
+	}
+}
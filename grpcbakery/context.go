@@ -0,0 +1,26 @@
+// Package grpcbakery provides gRPC server and client interceptors that
+// authenticate requests using macaroons, mirroring the way httpbakery
+// does it for HTTP.
+package grpcbakery
+
+import (
+	"golang.org/x/net/context"
+)
+
+// declaredKey is the type of the context key under which the declared
+// attributes of a successfully checked macaroon are stored.
+type declaredKey struct{}
+
+// NewContext returns ctx with declared attached, such that
+// DeclaredFromContext(ctx) will return it.
+func NewContext(ctx context.Context, declared map[string]string) context.Context {
+	return context.WithValue(ctx, declaredKey{}, declared)
+}
+
+// DeclaredFromContext returns the declared attributes that were checked by
+// a server interceptor for the request that ctx was derived from. It
+// returns nil if ctx holds no declared attributes.
+func DeclaredFromContext(ctx context.Context) map[string]string {
+	declared, _ := ctx.Value(declaredKey{}).(map[string]string)
+	return declared
+}
@@ -0,0 +1,69 @@
+package grpcbakery
+
+import (
+	"encoding/json"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gopkg.in/macaroon.v1"
+
+	"github.com/flynn/macaroon-bakery/httpbakery"
+)
+
+// dischargeMetadataKey is the trailer metadata key under which the
+// macaroon and discharge location of a DischargeRequiredError are
+// attached. The "-bin" suffix tells grpc-go to base64-encode the value,
+// since it is JSON rather than a UTF-8 string.
+const dischargeMetadataKey = "macaroon-discharge-required-bin"
+
+type dischargeDetails struct {
+	Macaroon *macaroon.Macaroon `json:"macaroon"`
+	Location string             `json:"location,omitempty"`
+}
+
+// toStatusError converts err to a gRPC status error suitable for
+// returning from a server interceptor. A *httpbakery.DischargeRequiredError
+// is converted to a FailedPrecondition status and, via ctx's outgoing
+// trailer, carries the macaroon and discharge location so that a
+// streaming client can recover it and drive the usual httpbakery
+// discharge flow. Any other error becomes a plain Unauthenticated status.
+func toStatusError(ctx context.Context, err error) error {
+	derr, ok := err.(*httpbakery.DischargeRequiredError)
+	if !ok {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	data, jerr := json.Marshal(dischargeDetails{
+		Macaroon: derr.Macaroon,
+		Location: derr.Macaroon.Location(),
+	})
+	if jerr != nil {
+		grpclog.Printf("grpcbakery: cannot marshal discharge details: %v", jerr)
+		return status.Error(codes.FailedPrecondition, derr.Error())
+	}
+	if serr := grpc.SetTrailer(ctx, metadata.Pairs(dischargeMetadataKey, string(data))); serr != nil {
+		grpclog.Printf("grpcbakery: cannot set discharge trailer: %v", serr)
+	}
+	return status.Error(codes.FailedPrecondition, derr.Error())
+}
+
+// DischargeRequiredErrorFromTrailer recovers the discharge information
+// attached by toStatusError to trailer, if any. It returns nil if
+// trailer carries no discharge details, for example because the error
+// the server returned was not a DischargeRequiredError.
+func DischargeRequiredErrorFromTrailer(trailer metadata.MD) *httpbakery.DischargeRequiredError {
+	vals := trailer[dischargeMetadataKey]
+	if len(vals) == 0 {
+		return nil
+	}
+	var details dischargeDetails
+	if json.Unmarshal([]byte(vals[0]), &details) != nil {
+		return nil
+	}
+	return &httpbakery.DischargeRequiredError{
+		Macaroon: details.Macaroon,
+	}
+}
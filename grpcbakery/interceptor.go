@@ -0,0 +1,173 @@
+package grpcbakery
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon.v1"
+
+	"github.com/flynn/macaroon-bakery/bakery"
+	"github.com/flynn/macaroon-bakery/bakery/checkers"
+)
+
+// macaroonMetadataKey is the metadata key under which a client attaches
+// its macaroon slice.
+const macaroonMetadataKey = "macaroon"
+
+// PermissionMap lets an operator require different caveats to be
+// satisfied for different RPC methods. It is keyed by the full gRPC
+// method name (for example "/my.pkg.Service/MyMethod").
+type PermissionMap map[string][]checkers.Caveat
+
+// extraCaveats returns the caveats required for method, if any.
+func (m PermissionMap) extraCaveats(method string) []checkers.Caveat {
+	if m == nil {
+		return nil
+	}
+	return m[method]
+}
+
+// UnaryServerInterceptor returns a grpc.UnaryServerInterceptor that
+// extracts a macaroon slice from the incoming request metadata, checks it
+// with svc and checker, and, on success, makes the declared attributes
+// available to the handler via DeclaredFromContext. perms may be nil, in
+// which case no per-method caveats are required beyond what checker
+// itself enforces.
+func UnaryServerInterceptor(svc *bakery.Service, checker checkers.Checker, perms PermissionMap) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		declared, err := checkRequest(ctx, svc, checker, perms.extraCaveats(info.FullMethod))
+		if err != nil {
+			return nil, toStatusError(ctx, err)
+		}
+		return handler(NewContext(ctx, declared), req)
+	}
+}
+
+// StreamServerInterceptor is the streaming equivalent of
+// UnaryServerInterceptor.
+func StreamServerInterceptor(svc *bakery.Service, checker checkers.Checker, perms PermissionMap) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		declared, err := checkRequest(ss.Context(), svc, checker, perms.extraCaveats(info.FullMethod))
+		if err != nil {
+			return toStatusError(ss.Context(), err)
+		}
+		return handler(srv, &contextServerStream{
+			ServerStream: ss,
+			ctx:          NewContext(ss.Context(), declared),
+		})
+	}
+}
+
+// contextServerStream overrides ServerStream.Context so that handlers
+// using a wrapped stream observe the declared attributes.
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func checkRequest(ctx context.Context, svc *bakery.Service, checker checkers.Checker, extra []checkers.Caveat) (map[string]string, error) {
+	ms, err := MacaroonsFromIncomingContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	declared := checkers.InferDeclared(ms)
+	composite := checkers.New(declared, checker)
+	if err := svc.Check(ms, composite); err != nil {
+		return nil, err
+	}
+	// extra holds the caveat conditions PermissionMap requires for
+	// this particular RPC method; they must hold in addition to
+	// whatever the macaroon's own caveats already enforced above, so
+	// check each one against the same composite checker rather than
+	// merely attaching it to a macaroon nobody re-verifies.
+	for _, cav := range extra {
+		if err := composite.CheckFirstPartyCaveat(cav.Condition); err != nil {
+			return nil, errgo.Notef(err, "required caveat %q not satisfied", cav.Condition)
+		}
+	}
+	return declared, nil
+}
+
+// MacaroonsFromIncomingContext returns the macaroon slice a client
+// attached to the incoming RPC whose context is ctx (see
+// UnaryClientInterceptor), for use by server-side code such as
+// permissions.RequirePermission that needs to check a macaroon outside
+// of the interceptors in this file.
+func MacaroonsFromIncomingContext(ctx context.Context) (macaroon.Slice, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md[macaroonMetadataKey]) == 0 {
+		return nil, errgo.New("no macaroon found in request metadata")
+	}
+	return decodeMacaroons(md[macaroonMetadataKey][0])
+}
+
+func decodeMacaroons(encoded string) (macaroon.Slice, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot base64-decode macaroon metadata")
+	}
+	var ms macaroon.Slice
+	if err := json.Unmarshal(data, &ms); err != nil {
+		return nil, errgo.Notef(err, "cannot unmarshal macaroon metadata")
+	}
+	return ms, nil
+}
+
+func encodeMacaroons(ms macaroon.Slice) (string, error) {
+	data, err := json.Marshal(ms)
+	if err != nil {
+		return "", errgo.Notef(err, "cannot marshal macaroons")
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// UnaryClientInterceptor returns a grpc.UnaryClientInterceptor that
+// attaches the macaroon slice held in ctx (see NewOutgoingContext) as
+// request metadata, so that a server using UnaryServerInterceptor can
+// authenticate the call.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, err := attachMacaroons(ctx)
+		if err != nil {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+func attachMacaroons(ctx context.Context) (context.Context, error) {
+	ms := MacaroonsFromOutgoingContext(ctx)
+	if len(ms) == 0 {
+		return ctx, nil
+	}
+	encoded, err := encodeMacaroons(ms)
+	if err != nil {
+		return nil, err
+	}
+	return metadata.AppendToOutgoingContext(ctx, macaroonMetadataKey, encoded), nil
+}
+
+// outgoingKey is the context key under which a client stores the
+// macaroon slice it wants UnaryClientInterceptor to attach.
+type outgoingKey struct{}
+
+// NewOutgoingContext returns ctx with ms attached, for use with a client
+// configured with UnaryClientInterceptor.
+func NewOutgoingContext(ctx context.Context, ms macaroon.Slice) context.Context {
+	return context.WithValue(ctx, outgoingKey{}, ms)
+}
+
+// MacaroonsFromOutgoingContext returns the macaroon slice previously
+// attached with NewOutgoingContext, or nil if there is none.
+func MacaroonsFromOutgoingContext(ctx context.Context) macaroon.Slice {
+	ms, _ := ctx.Value(outgoingKey{}).(macaroon.Slice)
+	return ms
+}
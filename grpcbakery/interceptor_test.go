@@ -0,0 +1,61 @@
+package grpcbakery
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"gopkg.in/macaroon.v1"
+
+	"github.com/flynn/macaroon-bakery/bakery"
+	"github.com/flynn/macaroon-bakery/bakery/checkers"
+)
+
+type noopChecker struct{}
+
+func (noopChecker) CheckFirstPartyCaveat(caveat string) error {
+	return checkers.ErrCaveatNotRecognized
+}
+
+func incomingContextWithMacaroon(t *testing.T, ms macaroon.Slice) context.Context {
+	t.Helper()
+	data, err := json.Marshal(ms)
+	if err != nil {
+		t.Fatalf("cannot marshal macaroons: %v", err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(data)
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(macaroonMetadataKey, encoded))
+}
+
+// TestUnaryServerInterceptorEnforcesPermissionMap is the regression test
+// for PermissionMap's per-method caveats: they must actually gate the
+// call, not merely be appended to a macaroon that is never re-checked.
+func TestUnaryServerInterceptorEnforcesPermissionMap(t *testing.T) {
+	svc, err := bakery.NewService(bakery.NewServiceParams{
+		Location: "test",
+		Store:    bakery.NewMemExpiringStorage(),
+	})
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	m, err := svc.NewMacaroon("", nil, nil)
+	if err != nil {
+		t.Fatalf("NewMacaroon: %v", err)
+	}
+	ctx := incomingContextWithMacaroon(t, macaroon.Slice{m})
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	perms := PermissionMap{
+		info.FullMethod: []checkers.Caveat{{Condition: "error always denied"}},
+	}
+	_, err = UnaryServerInterceptor(svc, noopChecker{}, perms)(ctx, "req", info, handler)
+	if err == nil {
+		t.Fatalf("PermissionMap caveat was not enforced: call should have been denied")
+	}
+}